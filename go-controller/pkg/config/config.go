@@ -0,0 +1,40 @@
+package config
+
+import (
+	"github.com/urfave/cli/v2"
+)
+
+// KubernetesConfig holds configuration values for how ovn-kubernetes node
+// processes interact with the Kubernetes API and the node's own Service
+// dataplane.
+type KubernetesConfig struct {
+	// BindNodePortLocalIP claims each NodePort on every node-local IP address
+	// individually instead of a single 0.0.0.0/:: socket, so that port claim
+	// doesn't conflict with hostNetwork pods bound to a specific node IP.
+	BindNodePortLocalIP bool
+	// EnableTProxy turns on the opt-in TPROXY mode for Service traffic
+	// redirect. It sets up the fwmark policy routing and the OVN-KUBE-TPROXY
+	// mangle chain only: no per-Service TPROXY rule or listener is installed
+	// (see tproxyForwardingImplemented in pkg/node), so Service traffic is
+	// not redirected and is not delivered to pods through TPROXY.
+	EnableTProxy bool
+}
+
+// Kubernetes holds the Kubernetes-related config values used by the node and
+// master processes.
+var Kubernetes = KubernetesConfig{}
+
+// Flags are the Kubernetes-related CLI flags that populate Kubernetes.
+var Flags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:        "bind-nodeport-local-ip",
+		Usage:       "Claim each NodePort on every node-local IP address individually instead of a single wildcard socket.",
+		Destination: &Kubernetes.BindNodePortLocalIP,
+	},
+	&cli.BoolFlag{
+		Name: "enable-tproxy",
+		Usage: "Enable TPROXY mode for Service traffic redirect. " +
+			"Only the policy routing and mangle chain are set up; no per-Service TPROXY rules are installed, so Service traffic is not redirected.",
+		Destination: &Kubernetes.EnableTProxy,
+	},
+}
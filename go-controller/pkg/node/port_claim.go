@@ -2,10 +2,15 @@ package node
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/config"
 	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/factory"
 	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/util"
 
@@ -22,19 +27,119 @@ type handler func(desc string, ip string, port int32, protocol kapi.Protocol, sv
 type localPortHandler interface {
 	open(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error
 	close(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error
+	installTProxy(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error
+	removeTProxy(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error
 }
 
 var portHandler localPortHandler
 
 var portOpener utilnet.PortOpener
 
+// sctpOpener claims SCTP ports. There is no "sctp" network understood by the
+// standard library's net.Listen, so this is implemented separately from
+// portOpener via a platform-specific userspace socket (see
+// port_claim_sctp_linux.go / port_claim_sctp_unsupported.go).
+var sctpOpener utilnet.PortOpener
+
 type portClaimWatcher struct {
 	recorder          record.EventRecorder
 	activeSocketsLock sync.Mutex
 	localAddrSet      map[string]net.IPNet
 	portsMap          map[utilnet.LocalPort]utilnet.Closeable
+	// nodePortLocalIPsMap tracks the per-local-IP sockets opened for a single
+	// NodePort claim when config.Kubernetes.BindNodePortLocalIP is enabled,
+	// keyed by the NodePort's own (ip=="") LocalPort. Each entry records which
+	// local IP it was bound to, so a single address can be added or removed
+	// from an existing claim without disturbing the others.
+	nodePortLocalIPsMap map[utilnet.LocalPort][]nodePortLocalSocket
+
+	lastClaimErrorLock sync.Mutex
+	// lastClaimError holds the most recent unrecovered OpenLocalPort error for
+	// each individual claim, so that it can be surfaced beyond the one-shot
+	// PortClaim event, e.g. via GetServicePortClaimStatus or the portclaim
+	// healthz check. Keyed per claimKey, not just per Service: a multi-port or
+	// multi-ExternalIP Service can have one port failing and another
+	// succeeding at the same time, and a success on one must not erase the
+	// still-outstanding failure on another.
+	lastClaimError map[claimKey]error
+
+	// watchFactory is retained so the address watcher can re-list cached
+	// Services to re-sync their claims when localAddrSet changes, without
+	// needing a second informer.
+	watchFactory *factory.WatchFactory
+
+	// tproxyRules tracks which LocalPorts currently have a TPROXY iptables
+	// rule installed, for config.Kubernetes.EnableTProxy mode. Guarded by
+	// activeSocketsLock.
+	tproxyRules map[utilnet.LocalPort]bool
+
+	// tproxyListeners tracks the shared IP_TRANSPARENT listener socket for
+	// each (port, protocol) pair that has at least one TPROXY rule installed
+	// under config.Kubernetes.EnableTProxy mode. The listener is always
+	// bound to the wildcard address and matched by destination port alone,
+	// so it has to be shared -- and reference-counted -- across every
+	// Service/ip that routes to the same port instead of one listener per
+	// LocalPort, or the second Service to reach a given port would fail to
+	// bind its own copy. Guarded by activeSocketsLock.
+	tproxyListeners map[tproxyListenerKey]*tproxyListenerEntry
+}
+
+// tproxyListenerKey identifies the single shared TPROXY listener socket for
+// a (port, protocol) pair.
+type tproxyListenerKey struct {
+	port     int32
+	protocol kapi.Protocol
+}
+
+// tproxyListenerEntry is a reference-counted handle on the listener shared by
+// every LocalPort with the same tproxyListenerKey; it's closed once the last
+// of them has its TPROXY rule removed.
+type tproxyListenerEntry struct {
+	closer   io.Closer
+	refCount int
+}
+
+// tproxyForwardingImplemented gates actually installing TPROXY rules/
+// listeners on Service events. The TPROXY listener only accepts and
+// discards connections/datagrams today -- it doesn't yet select a backend
+// pod endpoint and forward to it -- so turning on config.Kubernetes.EnableTProxy
+// must not start intercepting real Service traffic until that exists, or it
+// silently black-holes every matched connection. Flip this once forwarding
+// lands. A var (not a const) so tests can exercise the gated behavior.
+var tproxyForwardingImplemented = false
+
+// nodePortLocalSocket is one per-IP socket held open as part of a
+// BindNodePortLocalIP NodePort claim.
+type nodePortLocalSocket struct {
+	ip        string
+	closeable utilnet.Closeable
+}
+
+// claimKey identifies a single claimed (service, port, protocol, ip) tuple
+// for lastClaimError tracking. ip is "" for a NodePort claim that isn't bound
+// to any one local address (the plain wildcard path, or the canonical entry
+// for a BindNodePortLocalIP claim).
+type claimKey struct {
+	svc      types.NamespacedName
+	port     int32
+	protocol kapi.Protocol
+	ip       string
+}
+
+// addrChange describes a node-local address coming up or going away, as
+// reported by the platform's address watcher (see port_claim_addrs_linux.go
+// and port_claim_addrs_unsupported.go).
+type addrChange struct {
+	ip    string
+	ipNet net.IPNet
+	added bool
 }
 
+// portClaimStopCh is closed to stop the address watcher goroutine. Port
+// claim watching runs for the lifetime of the process today, so nothing
+// currently closes it.
+var portClaimStopCh = make(chan struct{})
+
 // Constants for valid LocalHost descriptions:
 const (
 	nodePortDescr     = "nodePort for"
@@ -43,10 +148,14 @@ const (
 
 func newPortClaimWatcher(recorder record.EventRecorder, localAddrSet map[string]net.IPNet) localPortHandler {
 	return &portClaimWatcher{
-		recorder:          recorder,
-		activeSocketsLock: sync.Mutex{},
-		portsMap:          make(map[utilnet.LocalPort]utilnet.Closeable),
-		localAddrSet:      localAddrSet,
+		recorder:            recorder,
+		activeSocketsLock:   sync.Mutex{},
+		portsMap:            make(map[utilnet.LocalPort]utilnet.Closeable),
+		nodePortLocalIPsMap: make(map[utilnet.LocalPort][]nodePortLocalSocket),
+		localAddrSet:        localAddrSet,
+		lastClaimError:      make(map[claimKey]error),
+		tproxyRules:         make(map[utilnet.LocalPort]bool),
+		tproxyListeners:     make(map[tproxyListenerKey]*tproxyListenerEntry),
 	}
 }
 
@@ -55,8 +164,33 @@ func initPortClaimWatcher(recorder record.EventRecorder, wf *factory.WatchFactor
 	if err != nil {
 		return err
 	}
-	portHandler = newPortClaimWatcher(recorder, localAddrSet)
+	pcw := newPortClaimWatcher(recorder, localAddrSet).(*portClaimWatcher)
+	pcw.watchFactory = wf
+	portHandler = pcw
 	portOpener = &utilnet.ListenPortOpener
+	sctpOpener = newSCTPPortOpener()
+	go pcw.runAddrWatcher()
+	http.HandleFunc("/healthz/portclaim", PortClaimHealthzHandler)
+
+	if config.Kubernetes.EnableTProxy {
+		// installTProxy refuses to actually install any per-Service rule
+		// until tproxyForwardingImplemented flips to true (see its doc
+		// comment), so this only sets up the routing/chain scaffolding that
+		// rule installation will need later: no Service's traffic is
+		// touched by enabling the flag today.
+		klog.Warningf("TPROXY mode is enabled, but endpoint forwarding is not implemented yet: " +
+			"no per-Service TPROXY rules will be installed until it is, only the base routing/chain scaffolding is being set up")
+		if err := setupTProxyRouting(); err != nil {
+			return err
+		}
+		go func() {
+			<-portClaimStopCh
+			if err := teardownTProxyRouting(); err != nil {
+				klog.Errorf("Error tearing down tproxy routing: %v", err)
+			}
+		}()
+	}
+
 	wf.AddServiceHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			svc := obj.(*kapi.Service)
@@ -65,6 +199,13 @@ func initPortClaimWatcher(recorder record.EventRecorder, wf *factory.WatchFactor
 					klog.Errorf("Error claiming port for service: %s/%s: %v", svc.Namespace, svc.Name, err)
 				}
 			}
+			if config.Kubernetes.EnableTProxy {
+				if errors := installServiceTProxy(svc); len(errors) > 0 {
+					for _, err := range errors {
+						klog.Errorf("Error installing tproxy rules for service: %s/%s: %v", svc.Namespace, svc.Name, err)
+					}
+				}
+			}
 		},
 		UpdateFunc: func(old, new interface{}) {
 			oldSvc := old.(*kapi.Service)
@@ -74,6 +215,13 @@ func initPortClaimWatcher(recorder record.EventRecorder, wf *factory.WatchFactor
 					klog.Errorf("Error updating port claim for service: %s/%s: %v", oldSvc.Namespace, oldSvc.Name, err)
 				}
 			}
+			if config.Kubernetes.EnableTProxy {
+				if errors := updateServiceTProxy(oldSvc, newSvc); len(errors) > 0 {
+					for _, err := range errors {
+						klog.Errorf("Error updating tproxy rules for service: %s/%s: %v", oldSvc.Namespace, oldSvc.Name, err)
+					}
+				}
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
 			svc := obj.(*kapi.Service)
@@ -82,6 +230,13 @@ func initPortClaimWatcher(recorder record.EventRecorder, wf *factory.WatchFactor
 					klog.Errorf("Error removing port claim for service: %s/%s: %v", svc.Namespace, svc.Name, err)
 				}
 			}
+			if config.Kubernetes.EnableTProxy {
+				if errors := removeServiceTProxy(svc); len(errors) > 0 {
+					for _, err := range errors {
+						klog.Errorf("Error removing tproxy rules for service: %s/%s: %v", svc.Namespace, svc.Name, err)
+					}
+				}
+			}
 		},
 	}, nil)
 	return nil
@@ -118,6 +273,53 @@ func handleService(svc *kapi.Service, handler handler) []error {
 	return errors
 }
 
+// handleServiceTProxy is the TPROXY analog of handleService: since TPROXY
+// redirects Service VIP traffic to pods without DNAT, it needs rules for the
+// ClusterIP as well as the NodePort, not just NodePort/ExternalIP.
+func handleServiceTProxy(svc *kapi.Service, handler handler) []error {
+	errors := []error{}
+	hasClusterIP := svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != kapi.ClusterIPNone
+	if !util.ServiceTypeHasNodePort(svc) && !hasClusterIP {
+		return errors
+	}
+
+	for _, svcPort := range svc.Spec.Ports {
+		if util.ServiceTypeHasNodePort(svc) {
+			klog.V(5).Infof("Handle NodePort service %s port %d for TPROXY", svc.Name, svcPort.NodePort)
+			if err := handlePort(getDescription(svcPort.Name, svc, true), svc, "", svcPort.NodePort, svcPort.Protocol, handler); err != nil {
+				errors = append(errors, err)
+			}
+		}
+		if hasClusterIP {
+			klog.V(5).Infof("Handle ClusterIP service %s port %d for TPROXY", svc.Name, svcPort.Port)
+			if err := handlePort(getDescription(svcPort.Name, svc, false), svc, svc.Spec.ClusterIP, svcPort.Port, svcPort.Protocol, handler); err != nil {
+				errors = append(errors, err)
+			}
+		}
+	}
+	return errors
+}
+
+func installServiceTProxy(svc *kapi.Service) []error {
+	return handleServiceTProxy(svc, portHandler.installTProxy)
+}
+
+func removeServiceTProxy(svc *kapi.Service) []error {
+	return handleServiceTProxy(svc, portHandler.removeTProxy)
+}
+
+func updateServiceTProxy(oldSvc, newSvc *kapi.Service) []error {
+	if reflect.DeepEqual(oldSvc.Spec.ClusterIP, newSvc.Spec.ClusterIP) &&
+		reflect.DeepEqual(oldSvc.Spec.Ports, newSvc.Spec.Ports) &&
+		reflect.DeepEqual(oldSvc.Spec.Type, newSvc.Spec.Type) {
+		return nil
+	}
+	errors := []error{}
+	errors = append(errors, removeServiceTProxy(oldSvc)...)
+	errors = append(errors, installServiceTProxy(newSvc)...)
+	return errors
+}
+
 // LocalPorts allows to add an arbitrary description, which can be used to distinguish LocalPorts instances having the
 // same networking parameters by created for different services.
 // kube-proxy and this implementation use the following format of the description: "
@@ -156,29 +358,57 @@ func updateServicePortClaim(oldSvc, newSvc *kapi.Service) []error {
 	return errors
 }
 
+// buildLocalPort constructs the LocalPort for desc/ip/port/protocol along with
+// the PortOpener that should be used to claim it.
+func buildLocalPort(desc, ip string, port int32, protocol kapi.Protocol) (*utilnet.LocalPort, utilnet.PortOpener, error) {
+	switch protocol {
+	case kapi.ProtocolTCP, kapi.ProtocolUDP:
+		localPort, err := utilnet.NewLocalPort(desc, ip, "", int(port), utilnet.Protocol(protocol))
+		return localPort, portOpener, err
+	case kapi.ProtocolSCTP:
+		// There is no connection tracking for SCTP in the kernel the way there
+		// is for TCP/UDP, ref: https://github.com/kubernetes/enhancements/blob/master/keps/sig-network/0015-20180614-SCTP-support.md#the-solution-in-the-kubernetes-sctp-support-implementation
+		// Claim the port by binding a userspace SCTP socket instead, so that a
+		// conflicting NodePort/ExternalIP is still detected.
+		localPort, err := utilnet.NewLocalPort(desc, ip, "", int(port), utilnet.Protocol(protocol))
+		return localPort, sctpOpener, err
+	default:
+		return nil, nil, fmt.Errorf("unknown protocol %q", protocol)
+	}
+}
+
+// isLocalAddr reports whether ip is currently one of the node's local
+// addresses. localAddrSet is also written by the address watcher goroutine
+// (see handleAddrChange), so this must always go through activeSocketsLock.
+func (p *portClaimWatcher) isLocalAddr(ip string) bool {
+	p.activeSocketsLock.Lock()
+	defer p.activeSocketsLock.Unlock()
+	_, exists := p.localAddrSet[ip]
+	return exists
+}
+
 func (p *portClaimWatcher) open(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error {
 	klog.V(5).Infof("Opening socket for service: %s/%s, port: %v and protocol %s", svc.Namespace, svc.Name, port, protocol)
 
 	if ip != "" {
-		if _, exists := p.localAddrSet[ip]; !exists {
+		if !p.isLocalAddr(ip) {
 			klog.V(5).Infof("The IP %s is not one of the node local ports", ip)
 			return nil
 		}
+	} else {
+		if tproxyForwardingImplemented && config.Kubernetes.EnableTProxy && (protocol == kapi.ProtocolTCP || protocol == kapi.ProtocolUDP) {
+			klog.V(5).Infof("Skipping plain NodePort claim for svc: %s/%s port: %v protocol %s: already exclusively bound by the shared tproxy listener", svc.Namespace, svc.Name, port, protocol)
+			return nil
+		}
+		if config.Kubernetes.BindNodePortLocalIP {
+			return p.openNodePortOnLocalIPs(desc, port, protocol, svc)
+		}
 	}
-	var localPort *utilnet.LocalPort
-	var portError error
-	switch protocol {
-	case kapi.ProtocolTCP, kapi.ProtocolUDP:
-		localPort, portError = utilnet.NewLocalPort(desc, ip, "", int(port), utilnet.Protocol(protocol))
-	case kapi.ProtocolSCTP:
-		// Do not open ports for SCTP, ref: https://github.com/kubernetes/enhancements/blob/master/keps/sig-network/0015-20180614-SCTP-support.md#the-solution-in-the-kubernetes-sctp-support-implementation
-		return nil
-	default:
-		portError = fmt.Errorf("unknown protocol %q", protocol)
-	}
-	if portError != nil {
-		p.emitPortClaimEvent(svc, port, portError)
-		return portError
+
+	localPort, opener, err := buildLocalPort(desc, ip, port, protocol)
+	if err != nil {
+		p.emitPortClaimEvent(svc, port, protocol, ip, err)
+		return err
 	}
 	klog.V(5).Infof("Opening socket for LocalPort %v", localPort)
 	p.activeSocketsLock.Lock()
@@ -187,27 +417,98 @@ func (p *portClaimWatcher) open(desc string, ip string, port int32, protocol kap
 	if _, exists := p.portsMap[*localPort]; exists {
 		return fmt.Errorf("error try to open socket for svc: %s/%s on port: %v again", svc.Namespace, svc.Name, port)
 	} else {
-		closeable, err := portOpener.OpenLocalPort(localPort)
+		closeable, err := opener.OpenLocalPort(localPort)
 		if err != nil {
-			p.emitPortClaimEvent(svc, port, err)
+			if protocol == kapi.ProtocolSCTP {
+				// SCTP port claim is best-effort: not every platform/kernel can
+				// bind an SCTP socket. Warn instead of treating this as fatal.
+				p.emitSCTPFallbackEvent(svc, port, err)
+				return nil
+			}
+			p.emitPortClaimEvent(svc, port, protocol, ip, err)
 			return err
 		}
 		p.portsMap[*localPort] = closeable
+		p.recordClaimSuccess(svc, port, protocol, ip)
+		metricPortClaimsOpenTotal.Inc()
+		metricPortClaimsActive.Inc()
 	}
 	return nil
 }
 
+// openNodePortOnLocalIPs claims a NodePort by binding one LocalPort per
+// node-local IP address instead of a single 0.0.0.0/:: socket, so that it
+// doesn't conflict with hostNetwork pods bound to a specific node IP.
+func (p *portClaimWatcher) openNodePortOnLocalIPs(desc string, port int32, protocol kapi.Protocol, svc *kapi.Service) error {
+	canonical, _, err := buildLocalPort(desc, "", port, protocol)
+	if err != nil {
+		p.emitPortClaimEvent(svc, port, protocol, "", err)
+		return err
+	}
+
+	p.activeSocketsLock.Lock()
+	defer p.activeSocketsLock.Unlock()
+
+	if _, exists := p.nodePortLocalIPsMap[*canonical]; exists {
+		return fmt.Errorf("error try to open socket for svc: %s/%s on port: %v again", svc.Namespace, svc.Name, port)
+	}
+
+	sockets := make([]nodePortLocalSocket, 0, len(p.localAddrSet))
+	for addr := range p.localAddrSet {
+		localPort, opener, err := buildLocalPort(desc, addr, port, protocol)
+		if err != nil {
+			closeAll(sockets)
+			p.emitPortClaimEvent(svc, port, protocol, "", err)
+			return err
+		}
+		klog.V(5).Infof("Opening socket for LocalPort %v", localPort)
+		closeable, err := opener.OpenLocalPort(localPort)
+		if err != nil {
+			closeAll(sockets)
+			if protocol == kapi.ProtocolSCTP {
+				p.emitSCTPFallbackEvent(svc, port, err)
+				return nil
+			}
+			p.emitPortClaimEvent(svc, port, protocol, "", err)
+			return err
+		}
+		sockets = append(sockets, nodePortLocalSocket{ip: addr, closeable: closeable})
+	}
+	p.nodePortLocalIPsMap[*canonical] = sockets
+	p.recordClaimSuccess(svc, port, protocol, "")
+	metricPortClaimsOpenTotal.Add(float64(len(sockets)))
+	metricPortClaimsActive.Add(float64(len(sockets)))
+	return nil
+}
+
+// closeAll releases every socket in sockets, logging (but not failing on)
+// errors encountered while unwinding a partially-opened claim.
+func closeAll(sockets []nodePortLocalSocket) {
+	for _, s := range sockets {
+		if err := s.closeable.Close(); err != nil {
+			klog.Warningf("Error closing socket while unwinding port claim: %v", err)
+		}
+	}
+}
+
 func (p *portClaimWatcher) close(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error {
 	klog.V(5).Infof("Closing socket claimed for service: %s/%s and port: %v", svc.Namespace, svc.Name, port)
 
-	if protocol != kapi.ProtocolTCP && protocol != kapi.ProtocolUDP {
+	if protocol != kapi.ProtocolTCP && protocol != kapi.ProtocolUDP && protocol != kapi.ProtocolSCTP {
 		return nil
 	}
 	if ip != "" {
-		if _, exists := p.localAddrSet[ip]; !exists {
+		if !p.isLocalAddr(ip) {
 			klog.V(5).Infof("The IP %s is not one of the node local ports", ip)
 			return nil
 		}
+	} else {
+		if tproxyForwardingImplemented && config.Kubernetes.EnableTProxy && (protocol == kapi.ProtocolTCP || protocol == kapi.ProtocolUDP) {
+			return nil
+		}
+		if config.Kubernetes.BindNodePortLocalIP {
+			return p.closeNodePortOnLocalIPs(desc, port, protocol, svc)
+		}
 	}
 	localPort, err := utilnet.NewLocalPort(desc, ip, "", int(port), utilnet.Protocol(protocol))
 	if err != nil {
@@ -215,6 +516,13 @@ func (p *portClaimWatcher) close(desc string, ip string, port int32, protocol ka
 	}
 	klog.V(5).Infof("Closing socket for LocalPort %v", localPort)
 
+	// The claim is going away either way below, so any previously recorded
+	// failure for it is no longer relevant -- leaving it in place would leak
+	// the entry forever (keyed by a NamespacedName that may be reused by an
+	// unrelated future Service) and keep reporting it via
+	// GetServicePortClaimStatus/the portclaim healthz check after the fact.
+	p.recordClaimSuccess(svc, port, protocol, ip)
+
 	p.activeSocketsLock.Lock()
 	defer p.activeSocketsLock.Unlock()
 
@@ -223,12 +531,60 @@ func (p *portClaimWatcher) close(desc string, ip string, port int32, protocol ka
 			return fmt.Errorf("error closing socket for svc: %s/%s on port: %v, err: %v", svc.Namespace, svc.Name, port, err)
 		}
 		delete(p.portsMap, *localPort)
+		metricPortClaimsActive.Dec()
+		return nil
+	}
+	if protocol == kapi.ProtocolSCTP {
+		// SCTP port claim is best-effort (see open()): on a host without
+		// SCTP support, open() never stored anything for this LocalPort, so
+		// there's nothing to release here. That's expected, not an error.
+		klog.V(5).Infof("No SCTP socket to close for svc: %s/%s on port: %v, was never opened", svc.Namespace, svc.Name, port)
 		return nil
 	}
 	return fmt.Errorf("error closing socket for svc: %s/%s on port: %v, port was never opened...?", svc.Namespace, svc.Name, port)
 }
 
-func (p *portClaimWatcher) emitPortClaimEvent(svc *kapi.Service, port int32, err error) {
+// closeNodePortOnLocalIPs releases every per-local-IP socket opened by
+// openNodePortOnLocalIPs for this NodePort claim.
+func (p *portClaimWatcher) closeNodePortOnLocalIPs(desc string, port int32, protocol kapi.Protocol, svc *kapi.Service) error {
+	canonical, err := utilnet.NewLocalPort(desc, "", "", int(port), utilnet.Protocol(protocol))
+	if err != nil {
+		return fmt.Errorf("error localPort creation for svc: %s/%s on port: %v, err: %v", svc.Namespace, svc.Name, port, err)
+	}
+
+	// See the matching comment in close(): the claim is going away either
+	// way below, so clear any previously recorded failure for it -- including
+	// any per-address entries extendNodePortClaimOnAddr may have recorded,
+	// not just the canonical one.
+	p.clearClaimErrorsForClaim(svc, port, protocol)
+
+	p.activeSocketsLock.Lock()
+	defer p.activeSocketsLock.Unlock()
+
+	sockets, exists := p.nodePortLocalIPsMap[*canonical]
+	if !exists {
+		if protocol == kapi.ProtocolSCTP {
+			klog.V(5).Infof("No SCTP sockets to close for svc: %s/%s on port: %v, were never opened", svc.Namespace, svc.Name, port)
+			return nil
+		}
+		return fmt.Errorf("error closing socket for svc: %s/%s on port: %v, port was never opened...?", svc.Namespace, svc.Name, port)
+	}
+
+	errors := []string{}
+	for _, s := range sockets {
+		if err := s.closeable.Close(); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+	delete(p.nodePortLocalIPsMap, *canonical)
+	metricPortClaimsActive.Sub(float64(len(sockets)))
+	if len(errors) > 0 {
+		return fmt.Errorf("error closing socket(s) for svc: %s/%s on port: %v, err: %v", svc.Namespace, svc.Name, port, errors)
+	}
+	return nil
+}
+
+func (p *portClaimWatcher) emitPortClaimEvent(svc *kapi.Service, port int32, protocol kapi.Protocol, ip string, err error) {
 	serviceRef := kapi.ObjectReference{
 		Kind:      "Service",
 		Namespace: svc.Namespace,
@@ -237,4 +593,331 @@ func (p *portClaimWatcher) emitPortClaimEvent(svc *kapi.Service, port int32, err
 	p.recorder.Eventf(&serviceRef, kapi.EventTypeWarning,
 		"PortClaim", "Service: %s/%s requires port: %v to be opened on node, but port cannot be opened, err: %v", svc.Namespace, svc.Name, port, err)
 	klog.Warningf("PortClaim for svc: %s/%s on port: %v, err: %v", svc.Namespace, svc.Name, port, err)
+
+	p.recordClaimFailure(svc, port, protocol, ip, err)
+	metricPortClaimsFailedTotal.Inc()
+}
+
+// emitSCTPFallbackEvent records that an SCTP port claim fell back to
+// best-effort because this node can't bind a raw SCTP socket (see
+// buildLocalPort). That's an expected, common condition -- not every
+// platform/kernel has the sctp module loaded -- so unlike
+// emitPortClaimEvent it must not mark the claim as failing: doing so would
+// make GetServicePortClaimStatus and the portclaim healthz check report
+// every SCTP service as permanently unhealthy on such a host.
+func (p *portClaimWatcher) emitSCTPFallbackEvent(svc *kapi.Service, port int32, err error) {
+	serviceRef := kapi.ObjectReference{
+		Kind:      "Service",
+		Namespace: svc.Namespace,
+		Name:      svc.Name,
+	}
+	p.recorder.Eventf(&serviceRef, kapi.EventTypeWarning,
+		"SCTPPortClaimUnavailable", "Service: %s/%s requires SCTP port: %v, but SCTP port claim is not available on this node, err: %v", svc.Namespace, svc.Name, port, err)
+	klog.Warningf("SCTP port claim not available for svc: %s/%s on port: %v, err: %v", svc.Namespace, svc.Name, port, err)
+
+	metricPortClaimsSCTPUnsupportedTotal.Inc()
+}
+
+// recordClaimFailure stores err as the most recent unrecovered port-claim
+// error for this specific (svc, port, protocol, ip) claim, so it can be
+// surfaced via GetServicePortClaimStatus and the portclaim healthz check. It
+// is tracked separately from any other claim of the same svc: one failing
+// port of a multi-port or multi-ExternalIP Service must not be masked by
+// another port of the same Service succeeding.
+func (p *portClaimWatcher) recordClaimFailure(svc *kapi.Service, port int32, protocol kapi.Protocol, ip string, err error) {
+	key := claimKey{svc: types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, port: port, protocol: protocol, ip: ip}
+	p.lastClaimErrorLock.Lock()
+	defer p.lastClaimErrorLock.Unlock()
+	p.lastClaimError[key] = err
+}
+
+// clearClaimErrorsForClaim deletes every lastClaimError entry recorded for
+// this (svc, port, protocol) claim, regardless of which address it's keyed
+// under. A BindNodePortLocalIP claim can accumulate per-address failures
+// recorded by extendNodePortClaimOnAddr in addition to the canonical (ip="")
+// entry, so tearing down the claim as a whole must clear all of them, not
+// just the canonical one.
+func (p *portClaimWatcher) clearClaimErrorsForClaim(svc *kapi.Service, port int32, protocol kapi.Protocol) {
+	svcName := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+	p.lastClaimErrorLock.Lock()
+	defer p.lastClaimErrorLock.Unlock()
+	for key := range p.lastClaimError {
+		if key.svc == svcName && key.port == port && key.protocol == protocol {
+			delete(p.lastClaimError, key)
+		}
+	}
+}
+
+// recordClaimSuccess clears any previously recorded port-claim error for this
+// specific (svc, port, protocol, ip) claim.
+func (p *portClaimWatcher) recordClaimSuccess(svc *kapi.Service, port int32, protocol kapi.Protocol, ip string) {
+	key := claimKey{svc: types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, port: port, protocol: protocol, ip: ip}
+	p.lastClaimErrorLock.Lock()
+	defer p.lastClaimErrorLock.Unlock()
+	delete(p.lastClaimError, key)
+}
+
+// GetServicePortClaimStatus returns the combined, most recently recorded
+// port-claim errors across every port/protocol/ip claimed by the given
+// service, and whether any are currently outstanding. A service with several
+// ports can have one failing and another succeeding at the same time, so all
+// outstanding errors for the service are joined together rather than only the
+// last one recorded.
+func GetServicePortClaimStatus(namespace, name string) (error, bool) {
+	pcw, ok := portHandler.(*portClaimWatcher)
+	if !ok || pcw == nil {
+		return nil, false
+	}
+	svcName := types.NamespacedName{Namespace: namespace, Name: name}
+	pcw.lastClaimErrorLock.Lock()
+	var errStrings []string
+	for key, err := range pcw.lastClaimError {
+		if key.svc == svcName {
+			errStrings = append(errStrings, fmt.Sprintf("port %d/%s ip %q: %v", key.port, key.protocol, key.ip, err))
+		}
+	}
+	pcw.lastClaimErrorLock.Unlock()
+	if len(errStrings) == 0 {
+		return nil, false
+	}
+	sort.Strings(errStrings)
+	return fmt.Errorf("%s", strings.Join(errStrings, "; ")), true
+}
+
+// runAddrWatcher keeps localAddrSet in sync with the node's actual addresses
+// so that ExternalIP claims don't go stale between Service updates, e.g. when
+// a secondary NIC comes up or a cloud provider reassigns an address.
+func (p *portClaimWatcher) runAddrWatcher() {
+	updates := make(chan addrChange, 10)
+	if err := watchLocalAddrs(updates, portClaimStopCh); err != nil {
+		klog.Errorf("Unable to watch node local addresses for port claim re-sync: %v", err)
+		return
+	}
+	for {
+		select {
+		case <-portClaimStopCh:
+			return
+		case change := <-updates:
+			p.handleAddrChange(change)
+		}
+	}
+}
+
+func (p *portClaimWatcher) handleAddrChange(change addrChange) {
+	p.activeSocketsLock.Lock()
+	if change.added {
+		p.localAddrSet[change.ip] = change.ipNet
+	} else {
+		delete(p.localAddrSet, change.ip)
+	}
+	p.activeSocketsLock.Unlock()
+
+	if change.added {
+		p.resyncServicesForAddr(change.ip)
+		return
+	}
+	p.releaseClaimsForAddr(change.ip)
+}
+
+// resyncServicesForAddr re-claims the ExternalIP ports for every cached
+// Service whose ExternalIPs includes the newly-added address ip, for use
+// when ip has just come up on the node. It only re-claims the specific
+// ExternalIP that changed, not the whole Service: addServicePortClaim would
+// replay every other port the Service already has open too, and log a
+// spurious claim failure for each one that's already bound.
+func (p *portClaimWatcher) resyncServicesForAddr(ip string) {
+	if p.watchFactory == nil {
+		return
+	}
+	services, err := p.watchFactory.GetServices()
+	if err != nil {
+		klog.Errorf("Unable to list services to re-sync port claim for address %s: %v", ip, err)
+		return
+	}
+	for _, svc := range services {
+		for _, externalIP := range svc.Spec.ExternalIPs {
+			if externalIP != ip {
+				continue
+			}
+			p.claimExternalIPOnAddr(svc, ip)
+			break
+		}
+		if config.Kubernetes.BindNodePortLocalIP && util.ServiceTypeHasNodePort(svc) {
+			p.extendNodePortClaimOnAddr(svc, ip)
+		}
+	}
+}
+
+// claimExternalIPOnAddr claims every ExternalIP port of svc on ip that isn't
+// already open, skipping the ones that are instead of replaying the whole
+// Service through handleService.
+func (p *portClaimWatcher) claimExternalIPOnAddr(svc *kapi.Service, ip string) {
+	for _, svcPort := range svc.Spec.Ports {
+		desc := getDescription(svcPort.Name, svc, false)
+		localPort, _, err := buildLocalPort(desc, ip, svcPort.Port, svcPort.Protocol)
+		if err != nil {
+			continue
+		}
+
+		p.activeSocketsLock.Lock()
+		_, exists := p.portsMap[*localPort]
+		p.activeSocketsLock.Unlock()
+		if exists {
+			continue
+		}
+
+		if err := handlePort(desc, svc, ip, svcPort.Port, svcPort.Protocol, portHandler.open); err != nil {
+			klog.Errorf("Error re-claiming port for service %s/%s after address %s came up: %v", svc.Namespace, svc.Name, ip, err)
+		}
+	}
+}
+
+// extendNodePortClaimOnAddr binds svc's already-established NodePort claims
+// onto the newly-added local address ip, under BindNodePortLocalIP mode. If
+// the claim hasn't been established on any address yet, it's left alone:
+// resyncServicesForAddr only re-claims ExternalIP ports, so such a NodePort
+// claim is made, on every current local address including ip, by
+// addServicePortClaim on a later Service event.
+func (p *portClaimWatcher) extendNodePortClaimOnAddr(svc *kapi.Service, ip string) {
+	for _, svcPort := range svc.Spec.Ports {
+		desc := getDescription(svcPort.Name, svc, true)
+		canonical, _, err := buildLocalPort(desc, "", svcPort.NodePort, svcPort.Protocol)
+		if err != nil {
+			continue
+		}
+
+		p.activeSocketsLock.Lock()
+		sockets, exists := p.nodePortLocalIPsMap[*canonical]
+		alreadyBound := false
+		for _, s := range sockets {
+			if s.ip == ip {
+				alreadyBound = true
+				break
+			}
+		}
+		p.activeSocketsLock.Unlock()
+		if !exists || alreadyBound {
+			continue
+		}
+
+		localPort, opener, err := buildLocalPort(desc, ip, svcPort.NodePort, svcPort.Protocol)
+		if err != nil {
+			if p.claimStillExists(canonical) {
+				p.emitPortClaimEvent(svc, svcPort.NodePort, svcPort.Protocol, ip, err)
+			}
+			continue
+		}
+		closeable, err := opener.OpenLocalPort(localPort)
+		if err != nil {
+			if svcPort.Protocol == kapi.ProtocolSCTP {
+				p.emitSCTPFallbackEvent(svc, svcPort.NodePort, err)
+				continue
+			}
+			if p.claimStillExists(canonical) {
+				// If the claim was torn down while OpenLocalPort was in
+				// flight, clearClaimErrorsForClaim has already run (or is
+				// about to); recording a failure here would leak right back
+				// in behind it for a claim that no longer exists.
+				p.emitPortClaimEvent(svc, svcPort.NodePort, svcPort.Protocol, ip, err)
+			}
+			continue
+		}
+
+		if !p.appendNodePortLocalSocket(canonical, ip, closeable) {
+			// The claim was removed (or ip was already bound by a
+			// concurrent caller) while OpenLocalPort was in flight above;
+			// the pre-open snapshot taken before we dropped the lock is no
+			// longer valid, so there's nothing left to attach this socket
+			// to. Release it instead of leaking it.
+			klog.V(5).Infof("NodePort claim for svc: %s/%s on port: %v no longer exists; releasing socket opened for address %s", svc.Namespace, svc.Name, svcPort.NodePort, ip)
+			if err := closeable.Close(); err != nil {
+				klog.Warningf("Error closing socket for svc: %s/%s on port: %v address %s after its claim vanished concurrently: %v", svc.Namespace, svc.Name, svcPort.NodePort, ip, err)
+			}
+			continue
+		}
+		p.recordClaimSuccess(svc, svcPort.NodePort, svcPort.Protocol, ip)
+		metricPortClaimsOpenTotal.Inc()
+		metricPortClaimsActive.Inc()
+	}
+}
+
+// claimStillExists reports whether canonical's NodePort claim still exists,
+// for use right before recording an extendNodePortClaimOnAddr failure: the
+// claim can be torn down (and its lastClaimError entries cleared via
+// clearClaimErrorsForClaim) while OpenLocalPort is in flight, and recording a
+// failure for it afterwards would leak right back in behind that teardown.
+func (p *portClaimWatcher) claimStillExists(canonical *utilnet.LocalPort) bool {
+	p.activeSocketsLock.Lock()
+	defer p.activeSocketsLock.Unlock()
+	_, exists := p.nodePortLocalIPsMap[*canonical]
+	return exists
+}
+
+// appendNodePortLocalSocket appends socket to canonical's entry in
+// nodePortLocalIPsMap as the one bound to ip, re-checking under
+// activeSocketsLock that the entry still exists and hasn't already been
+// extended to ip by a concurrent caller since extendNodePortClaimOnAddr took
+// its pre-open snapshot. Returns false if there was nothing left to append
+// to, in which case the caller owns closing socket itself.
+func (p *portClaimWatcher) appendNodePortLocalSocket(canonical *utilnet.LocalPort, ip string, socket utilnet.Closeable) bool {
+	p.activeSocketsLock.Lock()
+	defer p.activeSocketsLock.Unlock()
+	sockets, exists := p.nodePortLocalIPsMap[*canonical]
+	if !exists {
+		return false
+	}
+	for _, s := range sockets {
+		if s.ip == ip {
+			return false
+		}
+	}
+	p.nodePortLocalIPsMap[*canonical] = append(sockets, nodePortLocalSocket{ip: ip, closeable: socket})
+	return true
+}
+
+// releaseClaimsForAddr closes any LocalPort socket bound to ip, for use when
+// ip has just been removed from the node.
+func (p *portClaimWatcher) releaseClaimsForAddr(ip string) {
+	// The address itself is gone, so any claim failure previously recorded
+	// against it (e.g. by extendNodePortClaimOnAddr) is stale and would
+	// otherwise leak in lastClaimError forever.
+	p.lastClaimErrorLock.Lock()
+	for key := range p.lastClaimError {
+		if key.ip == ip {
+			delete(p.lastClaimError, key)
+		}
+	}
+	p.lastClaimErrorLock.Unlock()
+
+	p.activeSocketsLock.Lock()
+	defer p.activeSocketsLock.Unlock()
+	for lp, closeable := range p.portsMap {
+		if lp.IP != ip {
+			continue
+		}
+		if err := closeable.Close(); err != nil {
+			klog.Errorf("Error closing socket for %s after address %s was removed: %v", lp.String(), ip, err)
+		}
+		delete(p.portsMap, lp)
+		metricPortClaimsActive.Dec()
+	}
+
+	for lp, sockets := range p.nodePortLocalIPsMap {
+		remaining := sockets[:0]
+		for _, s := range sockets {
+			if s.ip != ip {
+				remaining = append(remaining, s)
+				continue
+			}
+			if err := s.closeable.Close(); err != nil {
+				klog.Errorf("Error closing NodePort socket for %s on address %s after it was removed: %v", lp.String(), ip, err)
+			}
+			metricPortClaimsActive.Dec()
+		}
+		if len(remaining) == 0 {
+			delete(p.nodePortLocalIPsMap, lp)
+		} else {
+			p.nodePortLocalIPsMap[lp] = remaining
+		}
+	}
 }
@@ -0,0 +1,37 @@
+//go:build linux
+// +build linux
+
+package node
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// watchLocalAddrs streams node-local address add/remove events from the
+// kernel via netlink onto updates, until stopCh is closed.
+func watchLocalAddrs(updates chan<- addrChange, stopCh <-chan struct{}) error {
+	addrCh := make(chan netlink.AddrUpdate)
+	done := make(chan struct{})
+	if err := netlink.AddrSubscribe(addrCh, done); err != nil {
+		return err
+	}
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case update, ok := <-addrCh:
+				if !ok {
+					return
+				}
+				updates <- addrChange{
+					ip:    update.LinkAddress.IP.String(),
+					ipNet: update.LinkAddress,
+					added: update.NewAddr,
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,48 @@
+//go:build !linux
+// +build !linux
+
+package node
+
+import (
+	"time"
+)
+
+// addrPollInterval is how often non-Linux platforms poll for node address
+// changes, since there is no portable netlink-style subscription available.
+const addrPollInterval = 30 * time.Second
+
+// watchLocalAddrs polls getLocalAddrs and diffs it against the previous
+// snapshot, pushing any add/remove onto updates until stopCh is closed.
+func watchLocalAddrs(updates chan<- addrChange, stopCh <-chan struct{}) error {
+	prev, err := getLocalAddrs()
+	if err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(addrPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				cur, err := getLocalAddrs()
+				if err != nil {
+					continue
+				}
+				for ip, ipNet := range cur {
+					if _, exists := prev[ip]; !exists {
+						updates <- addrChange{ip: ip, ipNet: ipNet, added: true}
+					}
+				}
+				for ip, ipNet := range prev {
+					if _, exists := cur[ip]; !exists {
+						updates <- addrChange{ip: ip, ipNet: ipNet, added: false}
+					}
+				}
+				prev = cur
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,74 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricPortClaimsOpenTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "ovnkube_node",
+		Name:      "port_claims_open_total",
+		Help:      "The total number of NodePort/ExternalIP sockets successfully claimed by this node",
+	})
+	metricPortClaimsFailedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "ovnkube_node",
+		Name:      "port_claims_failed_total",
+		Help:      "The total number of NodePort/ExternalIP port claims that failed on this node",
+	})
+	metricPortClaimsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "ovnkube_node",
+		Name:      "port_claims_active",
+		Help:      "The number of NodePort/ExternalIP sockets currently held open by this node",
+	})
+	metricPortClaimsSCTPUnsupportedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "ovnkube_node",
+		Name:      "port_claims_sctp_unsupported_total",
+		Help:      "The total number of SCTP port claims that fell back to best-effort because this node can't bind a raw SCTP socket",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricPortClaimsOpenTotal, metricPortClaimsFailedTotal, metricPortClaimsActive, metricPortClaimsSCTPUnsupportedTotal)
+}
+
+// PortClaimHealthzHandler reports unhealthy (503) when one or more services
+// have an unrecovered port-claim error, so it can be wired into the node's
+// health server at /healthz/portclaim.
+func PortClaimHealthzHandler(w http.ResponseWriter, r *http.Request) {
+	pcw, ok := portHandler.(*portClaimWatcher)
+	if !ok || pcw == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	pcw.lastClaimErrorLock.Lock()
+	failing := make(map[string][]string, len(pcw.lastClaimError))
+	for key, err := range pcw.lastClaimError {
+		svcName := key.svc.String()
+		failing[svcName] = append(failing[svcName], fmt.Sprintf("port %d/%s ip %q: %v", key.port, key.protocol, key.ip, err))
+	}
+	pcw.lastClaimErrorLock.Unlock()
+
+	if len(failing) == 0 {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	svcNames := make([]string, 0, len(failing))
+	for svcName := range failing {
+		svcNames = append(svcNames, svcName)
+	}
+	sort.Strings(svcNames)
+	for _, svcName := range svcNames {
+		errStrings := failing[svcName]
+		sort.Strings(errStrings)
+		fmt.Fprintf(w, "%s: %s\n", svcName, strings.Join(errStrings, "; "))
+	}
+}
@@ -0,0 +1,92 @@
+//go:build linux
+// +build linux
+
+package node
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	utilnet "k8s.io/utils/net"
+)
+
+// sctpPortOpener claims an SCTP port by binding a raw SCTP socket. The Go
+// standard library has no "sctp" network for net.Listen, so this talks to
+// the kernel directly via golang.org/x/sys/unix.
+type sctpPortOpener struct{}
+
+func newSCTPPortOpener() utilnet.PortOpener {
+	return &sctpPortOpener{}
+}
+
+func (s *sctpPortOpener) OpenLocalPort(lp *utilnet.LocalPort) (utilnet.Closeable, error) {
+	domain := sctpDomain(lp.IP)
+	fd, err := unix.Socket(domain, unix.SOCK_STREAM, unix.IPPROTO_SCTP)
+	if err != nil {
+		return nil, fmt.Errorf("can't open sctp socket for %s: %v", lp.String(), err)
+	}
+
+	if lp.IP == "" && domain == unix.AF_INET6 {
+		// Bind the wildcard dual-stack, the same way net.Listen("tcp", ":port")
+		// and the TPROXY wildcard listener (prepareTProxySocket) already do, so
+		// an IPv6 hostNetwork pod conflicting with this NodePort is detected
+		// too, not just an IPv4 one.
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, 0); err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("can't clear IPV6_V6ONLY for %s: %v", lp.String(), err)
+		}
+	}
+
+	sa, err := sctpSockaddr(domain, lp.IP, lp.Port)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("can't bind sctp socket for %s: %v", lp.String(), err)
+	}
+	if err := unix.Listen(fd, 1); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("can't listen on sctp socket for %s: %v", lp.String(), err)
+	}
+	return os.NewFile(uintptr(fd), lp.String()), nil
+}
+
+// sctpDomain picks the socket address family to bind an SCTP port-claim
+// socket in. An empty ip means "bind the wildcard address": like the TCP/UDP
+// path's net.Listen(..., ":port") and the TPROXY wildcard listener, that's
+// always bound dual-stack via AF_INET6 with IPV6_V6ONLY cleared, so it's
+// treated as IPv6 here rather than defaulting to AF_INET-only.
+func sctpDomain(ip string) int {
+	if ip == "" || utilnet.IsIPv6String(ip) {
+		return unix.AF_INET6
+	}
+	return unix.AF_INET
+}
+
+func sctpSockaddr(domain int, ip string, port int) (unix.Sockaddr, error) {
+	// An empty IP means "bind to all addresses"; leave the zero-value address
+	// in place for that case.
+	if ip == "" {
+		if domain == unix.AF_INET6 {
+			return &unix.SockaddrInet6{Port: port}, nil
+		}
+		return &unix.SockaddrInet4{Port: port}, nil
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, fmt.Errorf("invalid IP address %q for sctp socket", ip)
+	}
+	if domain == unix.AF_INET6 {
+		sa := &unix.SockaddrInet6{Port: port}
+		copy(sa.Addr[:], parsedIP.To16())
+		return sa, nil
+	}
+	sa := &unix.SockaddrInet4{Port: port}
+	copy(sa.Addr[:], parsedIP.To4())
+	return sa, nil
+}
@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package node
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSCTPDomain(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected int
+	}{
+		{ip: "", expected: unix.AF_INET6},
+		{ip: "1.2.3.4", expected: unix.AF_INET},
+		{ip: "fd00::1", expected: unix.AF_INET6},
+	}
+	for _, tt := range tests {
+		if got := sctpDomain(tt.ip); got != tt.expected {
+			t.Errorf("sctpDomain(%q) = %v, want %v", tt.ip, got, tt.expected)
+		}
+	}
+}
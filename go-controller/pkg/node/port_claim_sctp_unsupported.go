@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package node
+
+import (
+	"fmt"
+
+	utilnet "k8s.io/utils/net"
+)
+
+// sctpPortOpener is a no-op stand-in on platforms where we don't know how to
+// bind a raw SCTP socket. Port claim falls back to emitting a warning event
+// rather than failing the service.
+type sctpPortOpener struct{}
+
+func newSCTPPortOpener() utilnet.PortOpener {
+	return &sctpPortOpener{}
+}
+
+func (s *sctpPortOpener) OpenLocalPort(lp *utilnet.LocalPort) (utilnet.Closeable, error) {
+	return nil, fmt.Errorf("SCTP port claim is not supported on this platform")
+}
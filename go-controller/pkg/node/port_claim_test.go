@@ -0,0 +1,656 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ovn-org/ovn-kubernetes/go-controller/pkg/config"
+
+	kapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	utilnet "k8s.io/utils/net"
+)
+
+// fakePortOpener lets tests control whether OpenLocalPort succeeds, so the
+// SCTP/TCP open-close paths in port_claim.go can be exercised without
+// actually binding sockets.
+type fakePortOpener struct {
+	err error
+}
+
+func (f *fakePortOpener) OpenLocalPort(lp *utilnet.LocalPort) (utilnet.Closeable, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &fakeCloseable{}, nil
+}
+
+type fakeCloseable struct{}
+
+func (f *fakeCloseable) Close() error { return nil }
+
+// trackingCloseable records whether Close was called, so tests can assert a
+// socket opened mid-race was actually released rather than leaked.
+type trackingCloseable struct {
+	closed bool
+}
+
+func (c *trackingCloseable) Close() error {
+	c.closed = true
+	return nil
+}
+
+// fakePortOpenerFunc lets a test run arbitrary code (e.g. simulating a
+// concurrent claim removal) from inside OpenLocalPort.
+type fakePortOpenerFunc struct {
+	onOpen func() (utilnet.Closeable, error)
+}
+
+func (f *fakePortOpenerFunc) OpenLocalPort(lp *utilnet.LocalPort) (utilnet.Closeable, error) {
+	return f.onOpen()
+}
+
+func testService(protocol kapi.Protocol, nodePort int32) *kapi.Service {
+	return &kapi.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc"},
+		Spec: kapi.ServiceSpec{
+			Type: kapi.ServiceTypeNodePort,
+			Ports: []kapi.ServicePort{
+				{Protocol: protocol, NodePort: nodePort},
+			},
+		},
+	}
+}
+
+func newTestPortClaimWatcher() *portClaimWatcher {
+	return newTestPortClaimWatcherWithAddrs("1.2.3.4")
+}
+
+func newTestPortClaimWatcherWithAddrs(addrs ...string) *portClaimWatcher {
+	localAddrSet := make(map[string]net.IPNet, len(addrs))
+	for _, addr := range addrs {
+		localAddrSet[addr] = net.IPNet{IP: net.ParseIP(addr), Mask: net.CIDRMask(32, 32)}
+	}
+	return newPortClaimWatcher(record.NewFakeRecorder(10), localAddrSet).(*portClaimWatcher)
+}
+
+// TestOpenSCTPFallback verifies that a failed SCTP bind (the normal case on
+// hosts without the sctp kernel module) is treated as a best-effort, rather
+// than failing the service.
+func TestOpenSCTPFallback(t *testing.T) {
+	origOpener, origSCTP := portOpener, sctpOpener
+	defer func() { portOpener, sctpOpener = origOpener, origSCTP }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{err: fmt.Errorf("sctp not supported")}
+
+	p := newTestPortClaimWatcher()
+	svc := testService(kapi.ProtocolSCTP, 30001)
+
+	if err := p.open(getDescription("", svc, false), "1.2.3.4", 30001, kapi.ProtocolSCTP, svc); err != nil {
+		t.Fatalf("open() should be best-effort for SCTP, got err: %v", err)
+	}
+
+	localPort, err := utilnet.NewLocalPort(getDescription("", svc, false), "1.2.3.4", "", 30001, utilnet.Protocol(kapi.ProtocolSCTP))
+	if err != nil {
+		t.Fatalf("failed to build LocalPort: %v", err)
+	}
+	if _, exists := p.portsMap[*localPort]; exists {
+		t.Fatalf("portsMap should not contain an entry for a port that failed to bind")
+	}
+}
+
+// TestCloseSCTPNeverOpened verifies that close() doesn't error when asked to
+// release an SCTP port claim that open() never actually recorded, which
+// happens on every service lifecycle event on a host without SCTP support.
+func TestCloseSCTPNeverOpened(t *testing.T) {
+	origOpener, origSCTP := portOpener, sctpOpener
+	defer func() { portOpener, sctpOpener = origOpener, origSCTP }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{err: fmt.Errorf("sctp not supported")}
+
+	p := newTestPortClaimWatcher()
+	svc := testService(kapi.ProtocolSCTP, 30001)
+	desc := getDescription("", svc, false)
+
+	if err := p.open(desc, "1.2.3.4", 30001, kapi.ProtocolSCTP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error: %v", err)
+	}
+	if err := p.close(desc, "1.2.3.4", 30001, kapi.ProtocolSCTP, svc); err != nil {
+		t.Fatalf("close() should gracefully no-op for a never-opened SCTP port, got err: %v", err)
+	}
+}
+
+// TestOpenCloseTCP is the baseline open/close round trip for a claim that
+// actually succeeds, so the SCTP fallback tests above are exercising the
+// exceptional path and not the only path.
+func TestOpenCloseTCP(t *testing.T) {
+	origOpener, origSCTP := portOpener, sctpOpener
+	defer func() { portOpener, sctpOpener = origOpener, origSCTP }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{}
+
+	p := newTestPortClaimWatcher()
+	svc := testService(kapi.ProtocolTCP, 30002)
+	desc := getDescription("", svc, false)
+
+	if err := p.open(desc, "1.2.3.4", 30002, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error: %v", err)
+	}
+	localPort, err := utilnet.NewLocalPort(desc, "1.2.3.4", "", 30002, utilnet.Protocol(kapi.ProtocolTCP))
+	if err != nil {
+		t.Fatalf("failed to build LocalPort: %v", err)
+	}
+	if _, exists := p.portsMap[*localPort]; !exists {
+		t.Fatalf("portsMap should contain an entry after a successful open()")
+	}
+
+	if err := p.close(desc, "1.2.3.4", 30002, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("close() returned unexpected error: %v", err)
+	}
+	if _, exists := p.portsMap[*localPort]; exists {
+		t.Fatalf("portsMap should not contain an entry after close()")
+	}
+}
+
+// TestOpenCloseNodePortOnLocalIPs verifies the BindNodePortLocalIP path binds
+// one socket per node-local address for a NodePort claim, and close()
+// releases all of them again.
+func TestOpenCloseNodePortOnLocalIPs(t *testing.T) {
+	origOpener, origSCTP := portOpener, sctpOpener
+	defer func() { portOpener, sctpOpener = origOpener, origSCTP }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{}
+
+	origBindNodePortLocalIP := config.Kubernetes.BindNodePortLocalIP
+	defer func() { config.Kubernetes.BindNodePortLocalIP = origBindNodePortLocalIP }()
+	config.Kubernetes.BindNodePortLocalIP = true
+
+	p := newTestPortClaimWatcherWithAddrs("1.2.3.4", "5.6.7.8")
+	svc := testService(kapi.ProtocolTCP, 30003)
+	desc := getDescription("", svc, true)
+
+	if err := p.open(desc, "", 30003, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error: %v", err)
+	}
+
+	canonical, err := utilnet.NewLocalPort(desc, "", "", 30003, utilnet.Protocol(kapi.ProtocolTCP))
+	if err != nil {
+		t.Fatalf("failed to build LocalPort: %v", err)
+	}
+	sockets, exists := p.nodePortLocalIPsMap[*canonical]
+	if !exists {
+		t.Fatalf("nodePortLocalIPsMap should contain an entry after a successful open()")
+	}
+	if len(sockets) != 2 {
+		t.Fatalf("expected one socket per local address, got %d", len(sockets))
+	}
+
+	if err := p.close(desc, "", 30003, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("close() returned unexpected error: %v", err)
+	}
+	if _, exists := p.nodePortLocalIPsMap[*canonical]; exists {
+		t.Fatalf("nodePortLocalIPsMap should not contain an entry after close()")
+	}
+}
+
+// TestPortClaimHealthzAndStatus verifies that a failed claim is surfaced via
+// both GetServicePortClaimStatus and the /healthz/portclaim handler, and that
+// recovering the claim clears it from both again.
+func TestPortClaimHealthzAndStatus(t *testing.T) {
+	origOpener, origSCTP, origHandler := portOpener, sctpOpener, portHandler
+	defer func() { portOpener, sctpOpener, portHandler = origOpener, origSCTP, origHandler }()
+
+	p := newTestPortClaimWatcher()
+	portHandler = p
+	svc := testService(kapi.ProtocolTCP, 30004)
+	desc := getDescription("", svc, false)
+
+	portOpener = &fakePortOpener{err: fmt.Errorf("address already in use")}
+	if err := p.open(desc, "1.2.3.4", 30004, kapi.ProtocolTCP, svc); err == nil {
+		t.Fatalf("open() should have failed")
+	}
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); !failing || err == nil {
+		t.Fatalf("GetServicePortClaimStatus should report the failed claim, got err=%v failing=%v", err, failing)
+	}
+
+	rec := httptest.NewRecorder()
+	PortClaimHealthzHandler(rec, httptest.NewRequest("GET", "/healthz/portclaim", nil))
+	if rec.Code != 503 {
+		t.Fatalf("expected 503 while a claim is failing, got %d", rec.Code)
+	}
+
+	portOpener = &fakePortOpener{}
+	if err := p.open(desc, "1.2.3.4", 30004, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error on retry: %v", err)
+	}
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); failing || err != nil {
+		t.Fatalf("GetServicePortClaimStatus should clear after a successful claim, got err=%v failing=%v", err, failing)
+	}
+
+	rec = httptest.NewRecorder()
+	PortClaimHealthzHandler(rec, httptest.NewRequest("GET", "/healthz/portclaim", nil))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200 once the claim recovered, got %d", rec.Code)
+	}
+}
+
+// TestPortClaimHealthzAndStatusMultiPort verifies that a failure on one port
+// of a multi-port Service is not masked by another port of the same Service
+// succeeding: GetServicePortClaimStatus and the /healthz/portclaim handler
+// must keep reporting the Service as failing until every one of its ports
+// recovers.
+func TestPortClaimHealthzAndStatusMultiPort(t *testing.T) {
+	origOpener, origSCTP, origHandler := portOpener, sctpOpener, portHandler
+	defer func() { portOpener, sctpOpener, portHandler = origOpener, origSCTP, origHandler }()
+
+	p := newTestPortClaimWatcher()
+	portHandler = p
+	svc := testService(kapi.ProtocolTCP, 30005)
+	desc := getDescription("", svc, false)
+
+	portOpener = &fakePortOpener{err: fmt.Errorf("address already in use")}
+	if err := p.open(desc, "1.2.3.4", 30005, kapi.ProtocolTCP, svc); err == nil {
+		t.Fatalf("open() should have failed for port 30005")
+	}
+
+	portOpener = &fakePortOpener{}
+	if err := p.open(desc, "1.2.3.4", 30006, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error for port 30006: %v", err)
+	}
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); !failing || err == nil {
+		t.Fatalf("GetServicePortClaimStatus should still report the failing port 30005 even though port 30006 succeeded, got err=%v failing=%v", err, failing)
+	}
+
+	rec := httptest.NewRecorder()
+	PortClaimHealthzHandler(rec, httptest.NewRequest("GET", "/healthz/portclaim", nil))
+	if rec.Code != 503 {
+		t.Fatalf("expected 503 while port 30005 is still failing, got %d", rec.Code)
+	}
+
+	portOpener = &fakePortOpener{}
+	if err := p.open(desc, "1.2.3.4", 30005, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error on retry for port 30005: %v", err)
+	}
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); failing || err != nil {
+		t.Fatalf("GetServicePortClaimStatus should clear once every port recovers, got err=%v failing=%v", err, failing)
+	}
+}
+
+// TestCloseClearsClaimError verifies that close() clears a previously
+// recorded failure for a claim, so a Service deletion doesn't leak a stale
+// lastClaimError entry forever or mis-attribute it to an unrelated Service
+// that later reuses the same namespace/name.
+func TestCloseClearsClaimError(t *testing.T) {
+	origOpener, origSCTP, origHandler := portOpener, sctpOpener, portHandler
+	defer func() { portOpener, sctpOpener, portHandler = origOpener, origSCTP, origHandler }()
+
+	p := newTestPortClaimWatcher()
+	portHandler = p
+	svc := testService(kapi.ProtocolTCP, 30007)
+	desc := getDescription("", svc, false)
+
+	portOpener = &fakePortOpener{err: fmt.Errorf("address already in use")}
+	if err := p.open(desc, "1.2.3.4", 30007, kapi.ProtocolTCP, svc); err == nil {
+		t.Fatalf("open() should have failed")
+	}
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); !failing || err == nil {
+		t.Fatalf("GetServicePortClaimStatus should report the failed claim, got err=%v failing=%v", err, failing)
+	}
+
+	if err := p.close(desc, "1.2.3.4", 30007, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("close() returned unexpected error: %v", err)
+	}
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); failing || err != nil {
+		t.Fatalf("GetServicePortClaimStatus should no longer report a claim that was closed, got err=%v failing=%v", err, failing)
+	}
+}
+
+// TestReleaseClaimsForAddr verifies that releaseClaimsForAddr releases both a
+// plain ExternalIP socket and a BindNodePortLocalIP per-address socket bound
+// to the removed address, while leaving claims on other addresses alone.
+func TestReleaseClaimsForAddr(t *testing.T) {
+	origOpener, origSCTP := portOpener, sctpOpener
+	defer func() { portOpener, sctpOpener = origOpener, origSCTP }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{}
+
+	origBindNodePortLocalIP := config.Kubernetes.BindNodePortLocalIP
+	defer func() { config.Kubernetes.BindNodePortLocalIP = origBindNodePortLocalIP }()
+	config.Kubernetes.BindNodePortLocalIP = true
+
+	p := newTestPortClaimWatcherWithAddrs("1.2.3.4", "5.6.7.8")
+	extSvc := testService(kapi.ProtocolTCP, 0)
+	extSvc.Spec.ExternalIPs = []string{"1.2.3.4"}
+	extDesc := getDescription("", extSvc, false)
+	if err := p.open(extDesc, "1.2.3.4", 8080, kapi.ProtocolTCP, extSvc); err != nil {
+		t.Fatalf("open() for ExternalIP returned unexpected error: %v", err)
+	}
+
+	npSvc := testService(kapi.ProtocolTCP, 30005)
+	npDesc := getDescription("", npSvc, true)
+	if err := p.open(npDesc, "", 30005, kapi.ProtocolTCP, npSvc); err != nil {
+		t.Fatalf("open() for NodePort returned unexpected error: %v", err)
+	}
+
+	p.releaseClaimsForAddr("1.2.3.4")
+
+	extLocalPort, err := utilnet.NewLocalPort(extDesc, "1.2.3.4", "", 8080, utilnet.Protocol(kapi.ProtocolTCP))
+	if err != nil {
+		t.Fatalf("failed to build LocalPort: %v", err)
+	}
+	if _, exists := p.portsMap[*extLocalPort]; exists {
+		t.Fatalf("portsMap should no longer have the ExternalIP socket after releaseClaimsForAddr")
+	}
+
+	npCanonical, err := utilnet.NewLocalPort(npDesc, "", "", 30005, utilnet.Protocol(kapi.ProtocolTCP))
+	if err != nil {
+		t.Fatalf("failed to build LocalPort: %v", err)
+	}
+	sockets, exists := p.nodePortLocalIPsMap[*npCanonical]
+	if !exists {
+		t.Fatalf("nodePortLocalIPsMap entry should still exist, only the 1.2.3.4 socket should have been dropped")
+	}
+	for _, s := range sockets {
+		if s.ip == "1.2.3.4" {
+			t.Fatalf("the 1.2.3.4 socket should have been released from nodePortLocalIPsMap")
+		}
+	}
+	if len(sockets) != 1 {
+		t.Fatalf("expected exactly the 5.6.7.8 socket to remain, got %d sockets", len(sockets))
+	}
+}
+
+// TestExtendNodePortClaimOnAddr verifies that a BindNodePortLocalIP claim
+// already established on one address gets extended onto a second address
+// added later, instead of erroring like addServicePortClaim would.
+func TestExtendNodePortClaimOnAddr(t *testing.T) {
+	origOpener, origSCTP := portOpener, sctpOpener
+	defer func() { portOpener, sctpOpener = origOpener, origSCTP }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{}
+
+	origBindNodePortLocalIP := config.Kubernetes.BindNodePortLocalIP
+	defer func() { config.Kubernetes.BindNodePortLocalIP = origBindNodePortLocalIP }()
+	config.Kubernetes.BindNodePortLocalIP = true
+
+	p := newTestPortClaimWatcherWithAddrs("1.2.3.4")
+	svc := testService(kapi.ProtocolTCP, 30006)
+	desc := getDescription("", svc, true)
+	if err := p.open(desc, "", 30006, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error: %v", err)
+	}
+
+	p.activeSocketsLock.Lock()
+	p.localAddrSet["5.6.7.8"] = net.IPNet{IP: net.ParseIP("5.6.7.8"), Mask: net.CIDRMask(32, 32)}
+	p.activeSocketsLock.Unlock()
+
+	p.extendNodePortClaimOnAddr(svc, "5.6.7.8")
+
+	canonical, err := utilnet.NewLocalPort(desc, "", "", 30006, utilnet.Protocol(kapi.ProtocolTCP))
+	if err != nil {
+		t.Fatalf("failed to build LocalPort: %v", err)
+	}
+	sockets := p.nodePortLocalIPsMap[*canonical]
+	if len(sockets) != 2 {
+		t.Fatalf("expected the claim to be extended onto the new address, got %d sockets", len(sockets))
+	}
+
+	// Extending again onto the same address should be a no-op, not a second
+	// OpenLocalPort call.
+	p.extendNodePortClaimOnAddr(svc, "5.6.7.8")
+	if sockets := p.nodePortLocalIPsMap[*canonical]; len(sockets) != 2 {
+		t.Fatalf("extending an already-bound address again should be a no-op, got %d sockets", len(sockets))
+	}
+}
+
+// TestExtendNodePortClaimOnAddrFailureClearedByClose verifies that a failure
+// recorded by extendNodePortClaimOnAddr for one address of a
+// BindNodePortLocalIP claim is cleared when the whole claim is torn down,
+// not just the canonical entry.
+func TestExtendNodePortClaimOnAddrFailureClearedByClose(t *testing.T) {
+	origOpener, origSCTP, origHandler := portOpener, sctpOpener, portHandler
+	defer func() { portOpener, sctpOpener, portHandler = origOpener, origSCTP, origHandler }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{}
+
+	origBindNodePortLocalIP := config.Kubernetes.BindNodePortLocalIP
+	defer func() { config.Kubernetes.BindNodePortLocalIP = origBindNodePortLocalIP }()
+	config.Kubernetes.BindNodePortLocalIP = true
+
+	p := newTestPortClaimWatcherWithAddrs("1.2.3.4")
+	portHandler = p
+	svc := testService(kapi.ProtocolTCP, 30008)
+	desc := getDescription("", svc, true)
+	if err := p.open(desc, "", 30008, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error: %v", err)
+	}
+
+	p.activeSocketsLock.Lock()
+	p.localAddrSet["5.6.7.8"] = net.IPNet{IP: net.ParseIP("5.6.7.8"), Mask: net.CIDRMask(32, 32)}
+	p.activeSocketsLock.Unlock()
+
+	portOpener = &fakePortOpener{err: fmt.Errorf("address already in use")}
+	p.extendNodePortClaimOnAddr(svc, "5.6.7.8")
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); !failing || err == nil {
+		t.Fatalf("GetServicePortClaimStatus should report the failed extend onto 5.6.7.8, got err=%v failing=%v", err, failing)
+	}
+
+	if err := p.close(desc, "", 30008, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("close() returned unexpected error: %v", err)
+	}
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); failing || err != nil {
+		t.Fatalf("GetServicePortClaimStatus should clear the per-address failure once the claim is closed, got err=%v failing=%v", err, failing)
+	}
+}
+
+// TestReleaseClaimsForAddrClearsClaimError verifies that releaseClaimsForAddr
+// clears a previously recorded claim failure for the address being removed,
+// so it doesn't leak forever once the address itself is gone.
+func TestReleaseClaimsForAddrClearsClaimError(t *testing.T) {
+	origOpener, origSCTP, origHandler := portOpener, sctpOpener, portHandler
+	defer func() { portOpener, sctpOpener, portHandler = origOpener, origSCTP, origHandler }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{}
+
+	origBindNodePortLocalIP := config.Kubernetes.BindNodePortLocalIP
+	defer func() { config.Kubernetes.BindNodePortLocalIP = origBindNodePortLocalIP }()
+	config.Kubernetes.BindNodePortLocalIP = true
+
+	p := newTestPortClaimWatcherWithAddrs("1.2.3.4")
+	portHandler = p
+	svc := testService(kapi.ProtocolTCP, 30009)
+	desc := getDescription("", svc, true)
+	if err := p.open(desc, "", 30009, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error: %v", err)
+	}
+
+	p.activeSocketsLock.Lock()
+	p.localAddrSet["5.6.7.8"] = net.IPNet{IP: net.ParseIP("5.6.7.8"), Mask: net.CIDRMask(32, 32)}
+	p.activeSocketsLock.Unlock()
+
+	portOpener = &fakePortOpener{err: fmt.Errorf("address already in use")}
+	p.extendNodePortClaimOnAddr(svc, "5.6.7.8")
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); !failing || err == nil {
+		t.Fatalf("GetServicePortClaimStatus should report the failed extend onto 5.6.7.8, got err=%v failing=%v", err, failing)
+	}
+
+	p.releaseClaimsForAddr("5.6.7.8")
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); failing || err != nil {
+		t.Fatalf("GetServicePortClaimStatus should clear the failure once 5.6.7.8 itself is gone, got err=%v failing=%v", err, failing)
+	}
+}
+
+// TestOpenSkipsPlainNodePortUnderTProxy verifies that once tproxy forwarding
+// is wired in (tproxyForwardingImplemented), a NodePort TCP/UDP claim
+// doesn't also bind a plain socket on the same wildcard port the shared
+// tproxy listener already owns.
+func TestOpenSkipsPlainNodePortUnderTProxy(t *testing.T) {
+	origOpener, origSCTP := portOpener, sctpOpener
+	defer func() { portOpener, sctpOpener = origOpener, origSCTP }()
+	portOpener = &fakePortOpener{err: fmt.Errorf("plain NodePort claim should not be attempted")}
+	sctpOpener = &fakePortOpener{}
+
+	origEnableTProxy := config.Kubernetes.EnableTProxy
+	defer func() { config.Kubernetes.EnableTProxy = origEnableTProxy }()
+	config.Kubernetes.EnableTProxy = true
+
+	origForwardingImplemented := tproxyForwardingImplemented
+	defer func() { tproxyForwardingImplemented = origForwardingImplemented }()
+	tproxyForwardingImplemented = true
+
+	p := newTestPortClaimWatcher()
+	svc := testService(kapi.ProtocolTCP, 30010)
+	desc := getDescription("", svc, true)
+
+	if err := p.open(desc, "", 30010, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() should skip the plain claim rather than erroring, got: %v", err)
+	}
+	localPort, err := utilnet.NewLocalPort(desc, "", "", 30010, utilnet.Protocol(kapi.ProtocolTCP))
+	if err != nil {
+		t.Fatalf("failed to build LocalPort: %v", err)
+	}
+	if _, exists := p.portsMap[*localPort]; exists {
+		t.Fatalf("portsMap should not contain a plain NodePort entry when tproxy already owns the port")
+	}
+
+	if err := p.close(desc, "", 30010, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("close() should also skip the plain NodePort claim, got: %v", err)
+	}
+}
+
+// TestOpenSCTPFallbackDoesNotMarkFailing verifies that a best-effort SCTP
+// bind failure (see TestOpenSCTPFallback) doesn't get surfaced as a claim
+// failure via GetServicePortClaimStatus/the portclaim healthz check: unlike a
+// genuine bind failure, it's expected on any host without the sctp kernel
+// module loaded and must not make every SCTP service look permanently
+// unhealthy there.
+func TestOpenSCTPFallbackDoesNotMarkFailing(t *testing.T) {
+	origOpener, origSCTP, origHandler := portOpener, sctpOpener, portHandler
+	defer func() { portOpener, sctpOpener, portHandler = origOpener, origSCTP, origHandler }()
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{err: fmt.Errorf("sctp not supported")}
+
+	p := newTestPortClaimWatcher()
+	portHandler = p
+	svc := testService(kapi.ProtocolSCTP, 30009)
+
+	if err := p.open(getDescription("", svc, false), "1.2.3.4", 30009, kapi.ProtocolSCTP, svc); err != nil {
+		t.Fatalf("open() should be best-effort for SCTP, got err: %v", err)
+	}
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); failing || err != nil {
+		t.Fatalf("GetServicePortClaimStatus should not report an SCTP fallback as failing, got err=%v failing=%v", err, failing)
+	}
+
+	rec := httptest.NewRecorder()
+	PortClaimHealthzHandler(rec, httptest.NewRequest("GET", "/healthz/portclaim", nil))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200 for an SCTP fallback, not a reported claim failure, got %d", rec.Code)
+	}
+}
+
+// TestExtendNodePortClaimOnAddrRaceWithDelete verifies that if the NodePort
+// claim is removed while extendNodePortClaimOnAddr's OpenLocalPort call for
+// a newly-added address is still in flight, the socket it opens is released
+// instead of being used to recreate a claim for a Service that's already
+// gone.
+func TestExtendNodePortClaimOnAddrRaceWithDelete(t *testing.T) {
+	origOpener, origSCTP := portOpener, sctpOpener
+	defer func() { portOpener, sctpOpener = origOpener, origSCTP }()
+
+	origBindNodePortLocalIP := config.Kubernetes.BindNodePortLocalIP
+	defer func() { config.Kubernetes.BindNodePortLocalIP = origBindNodePortLocalIP }()
+	config.Kubernetes.BindNodePortLocalIP = true
+
+	p := newTestPortClaimWatcherWithAddrs("1.2.3.4")
+	svc := testService(kapi.ProtocolTCP, 30011)
+	desc := getDescription("", svc, true)
+
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{}
+	if err := p.open(desc, "", 30011, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error: %v", err)
+	}
+
+	p.activeSocketsLock.Lock()
+	p.localAddrSet["5.6.7.8"] = net.IPNet{IP: net.ParseIP("5.6.7.8"), Mask: net.CIDRMask(32, 32)}
+	p.activeSocketsLock.Unlock()
+
+	socket := &trackingCloseable{}
+	portOpener = &fakePortOpenerFunc{onOpen: func() (utilnet.Closeable, error) {
+		// Simulate the claim being torn down concurrently while this
+		// OpenLocalPort call for "5.6.7.8" is still in flight.
+		if err := p.close(desc, "", 30011, kapi.ProtocolTCP, svc); err != nil {
+			t.Fatalf("close() returned unexpected error: %v", err)
+		}
+		return socket, nil
+	}}
+
+	p.extendNodePortClaimOnAddr(svc, "5.6.7.8")
+
+	canonical, err := utilnet.NewLocalPort(desc, "", "", 30011, utilnet.Protocol(kapi.ProtocolTCP))
+	if err != nil {
+		t.Fatalf("failed to build LocalPort: %v", err)
+	}
+	if _, exists := p.nodePortLocalIPsMap[*canonical]; exists {
+		t.Fatalf("extendNodePortClaimOnAddr should not recreate a claim that was removed concurrently")
+	}
+	if !socket.closed {
+		t.Fatalf("the socket opened for the vanished claim should have been closed, not leaked")
+	}
+}
+
+// TestExtendNodePortClaimOnAddrFailureRaceWithDelete verifies that an
+// OpenLocalPort failure in extendNodePortClaimOnAddr doesn't reintroduce a
+// lastClaimError entry for a claim that was concurrently torn down (and thus
+// already had its claim errors cleared by clearClaimErrorsForClaim).
+func TestExtendNodePortClaimOnAddrFailureRaceWithDelete(t *testing.T) {
+	origOpener, origSCTP, origHandler := portOpener, sctpOpener, portHandler
+	defer func() { portOpener, sctpOpener, portHandler = origOpener, origSCTP, origHandler }()
+
+	origBindNodePortLocalIP := config.Kubernetes.BindNodePortLocalIP
+	defer func() { config.Kubernetes.BindNodePortLocalIP = origBindNodePortLocalIP }()
+	config.Kubernetes.BindNodePortLocalIP = true
+
+	p := newTestPortClaimWatcherWithAddrs("1.2.3.4")
+	portHandler = p
+	svc := testService(kapi.ProtocolTCP, 30012)
+	desc := getDescription("", svc, true)
+
+	portOpener = &fakePortOpener{}
+	sctpOpener = &fakePortOpener{}
+	if err := p.open(desc, "", 30012, kapi.ProtocolTCP, svc); err != nil {
+		t.Fatalf("open() returned unexpected error: %v", err)
+	}
+
+	p.activeSocketsLock.Lock()
+	p.localAddrSet["5.6.7.8"] = net.IPNet{IP: net.ParseIP("5.6.7.8"), Mask: net.CIDRMask(32, 32)}
+	p.activeSocketsLock.Unlock()
+
+	portOpener = &fakePortOpenerFunc{onOpen: func() (utilnet.Closeable, error) {
+		// Simulate the claim being torn down concurrently while this
+		// OpenLocalPort call for "5.6.7.8" is still in flight, then fail it.
+		if err := p.close(desc, "", 30012, kapi.ProtocolTCP, svc); err != nil {
+			t.Fatalf("close() returned unexpected error: %v", err)
+		}
+		return nil, fmt.Errorf("address already in use")
+	}}
+
+	p.extendNodePortClaimOnAddr(svc, "5.6.7.8")
+
+	if err, failing := GetServicePortClaimStatus(svc.Namespace, svc.Name); failing || err != nil {
+		t.Fatalf("GetServicePortClaimStatus should not report a failure for a claim that was deleted concurrently, got err=%v failing=%v", err, failing)
+	}
+}
@@ -0,0 +1,345 @@
+//go:build linux
+// +build linux
+
+package node
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	kapi "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	utilnet "k8s.io/utils/net"
+)
+
+const (
+	// tproxyFwMark marks packets that TPROXY has redirected to a local
+	// listener, so the policy route below can deliver them locally instead of
+	// following the normal routing table.
+	tproxyFwMark = 0x1
+	// tproxyRouteTable is the policy routing table used to route marked
+	// packets locally.
+	tproxyRouteTable = 100
+	tproxyChain      = "OVN-KUBE-TPROXY"
+)
+
+// setupTProxyRouting installs the fwmark policy route that delivers
+// TPROXY-marked packets to the local machine, so that a process listening
+// with IP_TRANSPARENT can accept them, and wires up the OVN-KUBE-TPROXY
+// mangle chain that installTProxy/removeTProxy add their per-Service rules
+// to.
+func setupTProxyRouting() error {
+	rule := netlink.NewRule()
+	rule.Mark = tproxyFwMark
+	rule.Table = tproxyRouteTable
+	if err := netlink.RuleAdd(rule); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to add tproxy policy routing rule: %v", err)
+	}
+
+	route := &netlink.Route{
+		Table: tproxyRouteTable,
+		Type:  unix.RTN_LOCAL,
+		Scope: netlink.SCOPE_HOST,
+		Dst:   nil,
+	}
+	if err := netlink.RouteAdd(route); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to add tproxy policy route: %v", err)
+	}
+
+	for _, proto := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		if err := setupTProxyChain(proto); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupTProxyChain creates the OVN-KUBE-TPROXY mangle chain that
+// installTProxy/removeTProxy append their per-Service TPROXY rules to, and
+// makes sure it's actually reached by jumping into it from PREROUTING.
+// go-iptables doesn't create chains referenced by Append/Delete on demand, so
+// without this, every installTProxy call fails with "Chain ... does not
+// exist".
+func setupTProxyChain(proto iptables.Protocol) error {
+	ipt, err := iptables.NewWithProtocol(proto)
+	if err != nil {
+		return fmt.Errorf("error creating iptables client for tproxy chain: %v", err)
+	}
+	// ClearChain creates the chain if it doesn't exist yet, or flushes it if
+	// it does, giving us a clean slate across restarts.
+	if err := ipt.ClearChain("mangle", tproxyChain); err != nil {
+		return fmt.Errorf("error creating tproxy chain: %v", err)
+	}
+	if err := ipt.InsertUnique("mangle", "PREROUTING", 1, "-j", tproxyChain); err != nil {
+		return fmt.Errorf("error adding tproxy jump rule: %v", err)
+	}
+	return nil
+}
+
+// teardownTProxyRouting removes the fwmark policy route and mangle chain
+// installed by setupTProxyRouting. Called on shutdown when TPROXY mode is
+// enabled.
+func teardownTProxyRouting() error {
+	rule := netlink.NewRule()
+	rule.Mark = tproxyFwMark
+	rule.Table = tproxyRouteTable
+	if err := netlink.RuleDel(rule); err != nil {
+		return fmt.Errorf("failed to remove tproxy policy routing rule: %v", err)
+	}
+
+	route := &netlink.Route{
+		Table: tproxyRouteTable,
+		Type:  unix.RTN_LOCAL,
+		Scope: netlink.SCOPE_HOST,
+		Dst:   nil,
+	}
+	if err := netlink.RouteDel(route); err != nil {
+		return fmt.Errorf("failed to remove tproxy policy route: %v", err)
+	}
+
+	for _, proto := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		if err := teardownTProxyChain(proto); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func teardownTProxyChain(proto iptables.Protocol) error {
+	ipt, err := iptables.NewWithProtocol(proto)
+	if err != nil {
+		return fmt.Errorf("error creating iptables client for tproxy chain: %v", err)
+	}
+	if err := ipt.Delete("mangle", "PREROUTING", "-j", tproxyChain); err != nil {
+		return fmt.Errorf("error removing tproxy jump rule: %v", err)
+	}
+	if err := ipt.ClearChain("mangle", tproxyChain); err != nil {
+		return fmt.Errorf("error flushing tproxy chain: %v", err)
+	}
+	if err := ipt.DeleteChain("mangle", tproxyChain); err != nil {
+		return fmt.Errorf("error deleting tproxy chain: %v", err)
+	}
+	return nil
+}
+
+func (p *portClaimWatcher) installTProxy(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error {
+	if protocol != kapi.ProtocolTCP && protocol != kapi.ProtocolUDP {
+		return nil
+	}
+	if !tproxyForwardingImplemented {
+		klog.V(5).Infof("Not installing tproxy rule for svc: %s/%s on port: %v: endpoint forwarding is not implemented yet", svc.Namespace, svc.Name, port)
+		return nil
+	}
+	localPort, err := utilnet.NewLocalPort(desc, ip, "", int(port), utilnet.Protocol(protocol))
+	if err != nil {
+		return fmt.Errorf("error localPort creation for svc: %s/%s tproxy on port: %v, err: %v", svc.Namespace, svc.Name, port, err)
+	}
+
+	p.activeSocketsLock.Lock()
+	defer p.activeSocketsLock.Unlock()
+	if p.tproxyRules[*localPort] {
+		return nil
+	}
+
+	listenerKey := tproxyListenerKey{port: port, protocol: protocol}
+	listener, listenerExists := p.tproxyListeners[listenerKey]
+	if !listenerExists {
+		closer, err := newTProxyListener(port, protocol)
+		if err != nil {
+			return fmt.Errorf("error opening tproxy listener for %s: %v", localPort.String(), err)
+		}
+		listener = &tproxyListenerEntry{closer: closer}
+		p.tproxyListeners[listenerKey] = listener
+	}
+
+	ipt, err := iptables.NewWithProtocol(tproxyIPTablesProtocol(ip))
+	if err != nil {
+		if !listenerExists {
+			listener.closer.Close()
+			delete(p.tproxyListeners, listenerKey)
+		}
+		return fmt.Errorf("error creating iptables client for tproxy rule %s: %v", localPort.String(), err)
+	}
+	if err := ipt.AppendUnique("mangle", tproxyChain, tproxyRuleSpec(ip, port, protocol)...); err != nil {
+		if !listenerExists {
+			listener.closer.Close()
+			delete(p.tproxyListeners, listenerKey)
+		}
+		return fmt.Errorf("error installing tproxy rule for %s: %v", localPort.String(), err)
+	}
+	listener.refCount++
+	p.tproxyRules[*localPort] = true
+	return nil
+}
+
+func (p *portClaimWatcher) removeTProxy(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error {
+	if protocol != kapi.ProtocolTCP && protocol != kapi.ProtocolUDP {
+		return nil
+	}
+	localPort, err := utilnet.NewLocalPort(desc, ip, "", int(port), utilnet.Protocol(protocol))
+	if err != nil {
+		return fmt.Errorf("error localPort creation for svc: %s/%s tproxy on port: %v, err: %v", svc.Namespace, svc.Name, port, err)
+	}
+
+	p.activeSocketsLock.Lock()
+	defer p.activeSocketsLock.Unlock()
+	if !p.tproxyRules[*localPort] {
+		return nil
+	}
+
+	ipt, err := iptables.NewWithProtocol(tproxyIPTablesProtocol(ip))
+	if err != nil {
+		return fmt.Errorf("error creating iptables client for tproxy rule %s: %v", localPort.String(), err)
+	}
+	if err := ipt.Delete("mangle", tproxyChain, tproxyRuleSpec(ip, port, protocol)...); err != nil {
+		return fmt.Errorf("error removing tproxy rule for %s: %v", localPort.String(), err)
+	}
+	delete(p.tproxyRules, *localPort)
+
+	listenerKey := tproxyListenerKey{port: port, protocol: protocol}
+	if listener, exists := p.tproxyListeners[listenerKey]; exists {
+		listener.refCount--
+		if listener.refCount <= 0 {
+			if err := listener.closer.Close(); err != nil {
+				klog.Warningf("Error closing tproxy listener for port %d/%s: %v", port, protocol, err)
+			}
+			delete(p.tproxyListeners, listenerKey)
+		}
+	}
+	return nil
+}
+
+// tproxyRuleSpec builds the mangle-table rule that marks and redirects
+// matching traffic to a local TPROXY listener on the same port.
+func tproxyRuleSpec(ip string, port int32, protocol kapi.Protocol) []string {
+	rule := []string{
+		"-p", strings.ToLower(string(protocol)),
+		"--dport", fmt.Sprintf("%d", port),
+		"-j", "TPROXY",
+		"--tproxy-mark", fmt.Sprintf("0x%x/0x%x", tproxyFwMark, tproxyFwMark),
+		"--on-port", fmt.Sprintf("%d", port),
+	}
+	if ip != "" {
+		rule = append([]string{"-d", ip}, rule...)
+	}
+	return rule
+}
+
+func tproxyIPTablesProtocol(ip string) iptables.Protocol {
+	if utilnet.IsIPv6String(ip) {
+		return iptables.ProtocolIPv6
+	}
+	return iptables.ProtocolIPv4
+}
+
+// newTProxyListener opens the IP_TRANSPARENT socket that the tproxyRuleSpec
+// iptables rule and the setupTProxyRouting policy route deliver marked
+// traffic to, and starts a goroutine to drain it. Without a socket actually
+// bound here, TPROXY-marked packets have nowhere to land and are dropped by
+// the kernel.
+//
+// NOT YET IMPLEMENTED: this does not select a backend pod endpoint and
+// forward to it, so it currently accepts connections/datagrams and drops
+// them rather than delivering them to a pod. config.Kubernetes.EnableTProxy
+// must not be turned on outside of TPROXY development/testing until this is
+// built out; see the warning logged in initPortClaimWatcher.
+func newTProxyListener(port int32, protocol kapi.Protocol) (io.Closer, error) {
+	if protocol == kapi.ProtocolUDP {
+		return newTProxyUDPListener(port)
+	}
+	return newTProxyTCPListener(port)
+}
+
+func newTProxyTCPListener(port int32) (io.Closer, error) {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error creating tproxy TCP socket: %v", err)
+	}
+	if err := prepareTProxySocket(fd, port); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	if err := unix.Listen(fd, 128); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("error listening on tproxy TCP socket: %v", err)
+	}
+
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("tproxy-tcp-%d", port))
+	listener, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping tproxy TCP socket: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// TODO: select a backend pod endpoint for the Service this port
+			// belongs to and forward conn's bytes to/from it. Until that
+			// exists, this just closes the connection immediately.
+			conn.Close()
+		}
+	}()
+	return listener, nil
+}
+
+func newTProxyUDPListener(port int32) (io.Closer, error) {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error creating tproxy UDP socket: %v", err)
+	}
+	if err := prepareTProxySocket(fd, port); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("tproxy-udp-%d", port))
+	conn, err := net.FilePacketConn(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping tproxy UDP socket: %v", err)
+	}
+
+	go func() {
+		// TODO: select a backend pod endpoint for the Service this port
+		// belongs to and forward each datagram to/from it. Until that
+		// exists, this just reads and discards.
+		buf := make([]byte, 2048)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return conn, nil
+}
+
+// prepareTProxySocket marks fd transparent and binds it to the wildcard
+// address on port, dual-stack, so it can accept traffic redirected for any
+// node-local IP the TPROXY rule matched.
+func prepareTProxySocket(fd int, port int32) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil {
+		return fmt.Errorf("error setting IP_TRANSPARENT: %v", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return fmt.Errorf("error setting SO_REUSEADDR: %v", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, 0); err != nil {
+		return fmt.Errorf("error clearing IPV6_V6ONLY: %v", err)
+	}
+	sa := &unix.SockaddrInet6{Port: int(port)}
+	if err := unix.Bind(fd, sa); err != nil {
+		return fmt.Errorf("error binding tproxy socket to port %d: %v", port, err)
+	}
+	return nil
+}
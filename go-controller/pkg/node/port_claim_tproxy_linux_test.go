@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package node
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	kapi "k8s.io/api/core/v1"
+)
+
+func TestTProxyRuleSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		port     int32
+		protocol kapi.Protocol
+		expected []string
+	}{
+		{
+			name:     "NodePort has no destination match",
+			ip:       "",
+			port:     30080,
+			protocol: kapi.ProtocolTCP,
+			expected: []string{
+				"-p", "tcp",
+				"--dport", "30080",
+				"-j", "TPROXY",
+				"--tproxy-mark", "0x1/0x1",
+				"--on-port", "30080",
+			},
+		},
+		{
+			name:     "ExternalIP/ClusterIP matches destination",
+			ip:       "1.2.3.4",
+			port:     80,
+			protocol: kapi.ProtocolUDP,
+			expected: []string{
+				"-d", "1.2.3.4",
+				"-p", "udp",
+				"--dport", "80",
+				"-j", "TPROXY",
+				"--tproxy-mark", "0x1/0x1",
+				"--on-port", "80",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tproxyRuleSpec(tt.ip, tt.port, tt.protocol)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("tproxyRuleSpec(%q, %d, %s) = %v, want %v", tt.ip, tt.port, tt.protocol, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTProxyIPTablesProtocol(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected iptables.Protocol
+	}{
+		{ip: "", expected: iptables.ProtocolIPv4},
+		{ip: "1.2.3.4", expected: iptables.ProtocolIPv4},
+		{ip: "fd00::1", expected: iptables.ProtocolIPv6},
+	}
+	for _, tt := range tests {
+		if got := tproxyIPTablesProtocol(tt.ip); got != tt.expected {
+			t.Errorf("tproxyIPTablesProtocol(%q) = %v, want %v", tt.ip, got, tt.expected)
+		}
+	}
+}
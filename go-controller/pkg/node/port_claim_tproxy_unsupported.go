@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package node
+
+import (
+	"fmt"
+
+	kapi "k8s.io/api/core/v1"
+)
+
+// setupTProxyRouting is a no-op stand-in on platforms without TPROXY/fwmark
+// policy routing support.
+func setupTProxyRouting() error {
+	return fmt.Errorf("TPROXY is not supported on this platform")
+}
+
+func teardownTProxyRouting() error {
+	return nil
+}
+
+func (p *portClaimWatcher) installTProxy(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error {
+	return fmt.Errorf("TPROXY is not supported on this platform")
+}
+
+func (p *portClaimWatcher) removeTProxy(desc string, ip string, port int32, protocol kapi.Protocol, svc *kapi.Service) error {
+	return nil
+}